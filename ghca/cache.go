@@ -0,0 +1,100 @@
+package ghca
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// cacheFileName is the name of the cache file Collector stores under Dest
+// when Incremental is enabled.
+const cacheFileName = ".ghca-cache.json"
+
+// CacheEntry records what Collector last knew about a cloned repository.
+type CacheEntry struct {
+	CloneURL      string           `json:"clone_url"`
+	DefaultBranch string           `json:"default_branch"`
+	PushedAt      github.Timestamp `json:"pushed_at"`
+	SHA           string           `json:"sha"`
+}
+
+// RepoCache is a JSON-file-backed record of previously cloned repositories, keyed
+// by slug ("owner/repo"). It backs Collector's Incremental mode, letting repeated
+// runs skip repositories that have not changed and update the rest in place
+// instead of cloning from scratch.
+type RepoCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+}
+
+// LoadRepoCache loads the cache file under dir, returning an empty cache if it
+// does not yet exist.
+func LoadRepoCache(dir string) (*RepoCache, error) {
+	c := &RepoCache{
+		path:    filepath.Join(dir, cacheFileName),
+		entries: make(map[string]CacheEntry),
+	}
+
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns the cached entry for slug, if any.
+func (c *RepoCache) Get(slug string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[slug]
+	return e, ok
+}
+
+// Set records entry for slug.
+func (c *RepoCache) Set(slug string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[slug] = entry
+}
+
+// Save persists the cache to its file under Dest.
+func (c *RepoCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, b, 0644)
+}
+
+// updateRepo brings an already-cloned repository at dir up to date with branch
+// on its origin remote, discarding any local changes.
+func updateRepo(dir, branch string) error {
+	if err := exec.Command("git", "-C", dir, "fetch", "origin", branch).Run(); err != nil {
+		return err
+	}
+	return exec.Command("git", "-C", dir, "reset", "--hard", "FETCH_HEAD").Run()
+}
+
+// headSHA returns the commit SHA currently checked out at dir.
+func headSHA(dir string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
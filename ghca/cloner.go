@@ -0,0 +1,188 @@
+package ghca
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// cloneWorkers is the number of repositories cloned concurrently.
+const cloneWorkers = 4
+
+// Cloner clones repositories (or gists) named by slug ("owner/repo", or
+// "owner/gistID" for gists) into Dest, running up to cloneWorkers clones at once.
+type Cloner struct {
+	dest    string
+	extract *regexp.Regexp
+	deep    bool
+	ssh     bool
+	host    string
+	metrics Metrics
+
+	jobs chan string
+	wg   sync.WaitGroup
+}
+
+// NewCloner creates a Cloner. host is the git hostname repositories are cloned
+// from, e.g. "github.com" or the host of an EndpointConfig.BaseURL. metrics
+// receives IncReposCloned, ObserveCloneDuration and AddCloneBytes for each
+// repository cloned successfully.
+func NewCloner(dest string, extract *regexp.Regexp, deep bool, ssh bool, host string, metrics Metrics) *Cloner {
+	return &Cloner{dest: dest, extract: extract, deep: deep, ssh: ssh, host: host, metrics: metrics}
+}
+
+// Start launches the worker pool backing Clone. count is a hint for how many
+// slugs will be cloned, used to avoid starting more workers than needed; 0 or
+// negative means unknown. Callers must call Shutdown once they stop calling Clone.
+func (c *Cloner) Start(count int) {
+	workers := cloneWorkers
+	if count > 0 && count < workers {
+		workers = count
+	}
+
+	c.jobs = make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		c.wg.Add(1)
+		go c.work()
+	}
+}
+
+func (c *Cloner) work() {
+	defer c.wg.Done()
+	for slug := range c.jobs {
+		if err := c.clone(slug); err != nil {
+			log.Printf("failed to clone %s: %s\n", slug, err)
+		}
+	}
+}
+
+// Clone enqueues slug to be cloned by the worker pool started with Start.
+func (c *Cloner) Clone(slug string) {
+	c.jobs <- slug
+}
+
+// Shutdown waits for every enqueued Clone to finish.
+func (c *Cloner) Shutdown() {
+	close(c.jobs)
+	c.wg.Wait()
+}
+
+// url builds the clone URL for slug using c.host and c.ssh.
+func (c *Cloner) url(slug string) string {
+	if c.ssh {
+		return fmt.Sprintf("git@%s:%s.git", c.host, slug)
+	}
+	return fmt.Sprintf("https://%s/%s.git", c.host, slug)
+}
+
+func (c *Cloner) clone(slug string) error {
+	dir := filepath.Join(c.dest, slug)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+
+	args := []string{"clone"}
+	if !c.deep {
+		args = append(args, "--depth", "1")
+	}
+	args = append(args, c.url(slug), dir)
+	start := time.Now()
+	if err := exec.Command("git", args...).Run(); err != nil {
+		return err
+	}
+	c.metrics.ObserveCloneDuration(time.Now().Sub(start).Seconds())
+	c.metrics.IncReposCloned(1)
+
+	if size, err := dirSize(dir); err == nil {
+		c.metrics.AddCloneBytes(size)
+	}
+
+	if c.extract != nil {
+		return c.pruneNonMatching(dir)
+	}
+	return nil
+}
+
+// dirSize returns the total size in bytes of every regular file under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// pruneNonMatching removes every file under dir whose path (relative to dir)
+// does not match c.extract, along with any directory left empty as a result.
+func (c *Cloner) pruneNonMatching(dir string) error {
+	var matched []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if c.extract.MatchString(rel) {
+			matched = append(matched, path)
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return removeEmptyDirs(dir, matched)
+}
+
+// removeEmptyDirs removes every directory under dir that does not contain (even
+// transitively) one of the kept files.
+func removeEmptyDirs(dir string, kept []string) error {
+	keep := make(map[string]bool)
+	for _, f := range kept {
+		for d := filepath.Dir(f); d != dir; d = filepath.Dir(d) {
+			keep[d] = true
+		}
+	}
+
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Walk lists parents before children; removing in reverse visits children
+	// first, so a directory is actually empty by the time we reach it. os.Remove
+	// on a directory holding a kept file fails and is ignored, as intended.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if !keep[d] {
+			os.Remove(d)
+		}
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package ghca
 import (
 	"context"
 	"fmt"
-	"log"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 
@@ -35,65 +37,348 @@ type Collector struct {
 	// Deep indicates shallow clone is not used
 	Deep bool
 	// SSH indicates use of SSH protocol instead of HTTPS
-	SSH    bool
-	client *github.Client
+	SSH bool
+	// Sort is the field results are sorted by, e.g. "stars", "forks" or "updated".
+	// When empty, results are sorted by best match as usual.
+	Sort string
+	// Order is the sort direction, "asc" or "desc". It has no effect when Sort is empty.
+	Order string
+	// Filter, when non-nil, is evaluated against each searched repository before
+	// cloning it. Repositories for which it returns false are skipped and do not
+	// count towards Count. It is not consulted when Source is SourceGist.
+	Filter func(*github.Repository) bool
+	// Source selects which GitHub API Collect enumerates from. Query is
+	// reinterpreted as a login for every Source other than SourceSearch.
+	// Defaults to SourceSearch.
+	Source Source
+	// Incremental, when true, backs Collect with a RepoCache under Dest: a
+	// repository whose pushed_at has not changed since the last run is skipped,
+	// and one that has is updated in place with a fetch+reset instead of being
+	// cloned from scratch.
+	Incremental bool
+	// cache is lazily loaded the first time Incremental is used.
+	cache *RepoCache
+	// Logger receives structured events from Collect. Defaults to StdLogger.
+	Logger Logger
+	// Metrics receives counters from Collect. Defaults to NopMetrics.
+	Metrics Metrics
+	// host is the hostname repositories are cloned from, derived from the
+	// enterprise endpoint when one is configured, or "github.com" otherwise.
+	host string
+	// clients holds one *github.Client per token, so that Collect can rotate
+	// to a fresh token once the active one hits the Search API's rate limit.
+	clients []*github.Client
+	// resets holds, per client, the Rate.Reset time last observed for it.
+	resets []time.Time
+	idx    int
 	ctx    context.Context
 }
 
-func (col *Collector) searchRepos() (*github.RepositoriesSearchResult, error) {
+// Source selects which GitHub API Collect enumerates repositories (or gists) from.
+// The Search API caps results at 1000 regardless of PageConfig, so the other
+// sources exist to mirror an entire org, user, or gist collection past that ceiling.
+type Source string
+
+const (
+	// SourceSearch enumerates repositories matching Query via the Search API. This is the default.
+	SourceSearch Source = "search"
+	// SourceOrg enumerates every repository owned by the organization named by Query.
+	SourceOrg Source = "org"
+	// SourceUser enumerates every repository owned by the user named by Query.
+	SourceUser Source = "user"
+	// SourceGist enumerates every gist owned by the user named by Query.
+	SourceGist Source = "gist"
+)
+
+// EndpointConfig represents configuration to talk to a GitHub Enterprise Server
+// instance instead of the public github.com API.
+type EndpointConfig struct {
+	// BaseURL is the API base URL of the enterprise instance, e.g.
+	// "https://github.example.com/api/v3/".
+	BaseURL string
+	// UploadURL is the API upload URL of the enterprise instance, e.g.
+	// "https://github.example.com/api/uploads/". When empty, BaseURL is used.
+	UploadURL string
+}
+
+// source returns col.Source, defaulting to SourceSearch.
+func (col *Collector) source() Source {
+	if col.Source == "" {
+		return SourceSearch
+	}
+	return col.Source
+}
+
+func (col *Collector) searchRepos() ([]*github.Repository, int, *github.Response, error) {
 	o := &github.SearchOptions{
+		Sort:  col.Sort,
+		Order: col.Order,
 		ListOptions: github.ListOptions{
 			Page:    int(col.page),
 			PerPage: int(col.perPage),
 		},
 	}
-	r, _, err := col.client.Search.Repositories(col.ctx, col.Query, o)
+	res, resp, err := col.clients[col.idx].Search.Repositories(col.ctx, col.Query, o)
 	if err != nil {
-		return nil, err
+		return nil, 0, resp, err
+	}
+	if res.GetIncompleteResults() {
+		col.Logger.Log("TODO: handle incomplete result returned from GitHub API", "query", col.Query)
+	}
+	repos := make([]*github.Repository, len(res.Repositories))
+	for i := range res.Repositories {
+		repos[i] = &res.Repositories[i]
+	}
+	return repos, res.GetTotal(), resp, nil
+}
+
+func (col *Collector) listByOrg() ([]*github.Repository, *github.Response, error) {
+	o := &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{Page: int(col.page), PerPage: int(col.perPage)},
+	}
+	return col.clients[col.idx].Repositories.ListByOrg(col.ctx, col.Query, o)
+}
+
+func (col *Collector) listByUser() ([]*github.Repository, *github.Response, error) {
+	o := &github.RepositoryListOptions{
+		ListOptions: github.ListOptions{Page: int(col.page), PerPage: int(col.perPage)},
+	}
+	return col.clients[col.idx].Repositories.List(col.ctx, col.Query, o)
+}
+
+func (col *Collector) listGists() ([]*github.Gist, *github.Response, error) {
+	o := &github.GistListOptions{
+		ListOptions: github.ListOptions{Page: int(col.page), PerPage: int(col.perPage)},
+	}
+	return col.clients[col.idx].Gists.List(col.ctx, col.Query, o)
+}
+
+// rotate switches to the next client whose rate limit has already reset. When every
+// client is still rate-limited, it sleeps only until the earliest of their reset times.
+func (col *Collector) rotate() {
+	now := time.Now()
+	for i := 1; i <= len(col.clients); i++ {
+		next := (col.idx + i) % len(col.clients)
+		if col.resets[next].Before(now) {
+			col.idx = next
+			return
+		}
+	}
+
+	earliest := col.resets[0]
+	for _, t := range col.resets[1:] {
+		if t.Before(earliest) {
+			earliest = t
+		}
+	}
+	if wait := earliest.Sub(now); wait > 0 {
+		col.Logger.Log("rate limit exceeded on all tokens", "sleep", wait.String(), "rate_reset", earliest)
+		time.Sleep(wait)
 	}
-	return r, nil
 }
 
-// Collect collects all repositories based on results of GitHub Search API. It returns total number
-// of atucally cloned repositories and total number of repositories on GitHub.
+// trackRate records the active client's rate-limit state and rotates to another
+// client when it is exhausted. It reports whether the caller should retry the request.
+func (col *Collector) trackRate(resp *github.Response, err error) bool {
+	if rlErr, ok := err.(*github.RateLimitError); ok {
+		col.Metrics.IncRateLimitHits()
+		col.resets[col.idx] = rlErr.Rate.Reset.Time
+		col.rotate()
+		return true
+	}
+	if resp != nil {
+		col.resets[col.idx] = resp.Rate.Reset.Time
+		if resp.Rate.Remaining <= 1 {
+			col.rotate()
+		}
+	}
+	return false
+}
+
+// Collect collects all repositories (or gists, for SourceGist) based on col.Source and
+// clones them all. It returns the number of actually cloned items and the total number
+// found on GitHub.
 func (col *Collector) Collect() (int, int, error) {
-	log.Println("Searching GitHub repositories with query:", col.Query)
+	col.resolveMaxPage()
+	if col.source() == SourceGist {
+		return col.collectGists()
+	}
+	return col.collectRepos()
+}
+
+// resolveMaxPage fills in col.maxPage when it was left at PageUnlimited. Only
+// SourceSearch is capped at 1000 results; ListByOrg, List and Gists.List are
+// paginated without that ceiling, so those sources are left truly unbounded and
+// rely on an empty page (or a zero NextPage) to end the Fetch loop.
+func (col *Collector) resolveMaxPage() {
+	if col.maxPage != PageUnlimited {
+		return
+	}
+	if col.source() != SourceSearch {
+		col.maxPage = ^uint(0)
+		return
+	}
+	maxRepos := 1000.0
+	if 0 < col.Count && col.Count < 1000 {
+		maxRepos = float64(col.Count)
+	}
+	col.maxPage = uint(math.Ceil(maxRepos / float64(col.perPage)))
+}
+
+// collectRepos drives SourceSearch, SourceOrg and SourceUser.
+func (col *Collector) collectRepos() (int, int, error) {
+	col.Logger.Log("fetching repositories", "source", col.source(), "query", col.Query)
 	start := time.Now()
-	cloner := NewCloner(col.Dest, col.Extract, col.Deep, col.SSH)
+	cloner := NewCloner(col.Dest, col.Extract, col.Deep, col.SSH, col.host, col.Metrics)
 	if !col.Dry {
 		cloner.Start(col.Count)
 	}
 
+	if col.Incremental && col.cache == nil {
+		cache, err := LoadRepoCache(col.Dest)
+		if err != nil {
+			return 0, 0, err
+		}
+		col.cache = cache
+	}
+
 	total := 0
+	enumerated := 0
 	count := 0
 Fetch:
 	for col.page <= col.maxPage {
-		res, err := col.searchRepos()
-		if _, ok := err.(*github.RateLimitError); ok {
-			log.Println("Rate limit exceeded. Sleeping 1 minute")
-			time.Sleep(1 * time.Minute)
+		var repos []*github.Repository
+		var resp *github.Response
+		var err error
+		switch col.source() {
+		case SourceOrg:
+			repos, resp, err = col.listByOrg()
+		case SourceUser:
+			repos, resp, err = col.listByUser()
+		default:
+			repos, total, resp, err = col.searchRepos()
+		}
+
+		if col.trackRate(resp, err) {
 			continue
 		} else if err != nil {
+			col.Metrics.IncAPIErrors()
 			return 0, 0, err
 		}
 
-		total = res.GetTotal()
+		if len(repos) == 0 {
+			// Everything was enumerated
+			break
+		}
+		col.Metrics.IncReposEnumerated(len(repos))
+		enumerated += len(repos)
+
+		for _, repo := range repos {
+			if col.Filter != nil && !col.Filter(repo) {
+				continue
+			}
+
+			slug := fmt.Sprintf("%s/%s", repo.GetOwner().GetLogin(), repo.GetName())
+			dir := filepath.Join(col.Dest, slug)
+			_, statErr := os.Stat(dir)
+			dirExists := statErr == nil
+
+			if col.Incremental && !col.Dry {
+				if entry, ok := col.cache.Get(slug); ok && entry.PushedAt.Equal(repo.GetPushedAt()) && dirExists {
+					continue
+				}
+			}
+
+			if col.Dry {
+				fmt.Printf("dry-run: %s: %s\n", slug, repo.GetDescription())
+			} else {
+				if col.Incremental && dirExists {
+					updateStart := time.Now()
+					if err := updateRepo(dir, repo.GetDefaultBranch()); err != nil {
+						return 0, 0, err
+					}
+					col.Metrics.ObserveCloneDuration(time.Now().Sub(updateStart).Seconds())
+					col.Metrics.IncReposCloned(1)
+				} else {
+					// The cloner reports clone metrics once the clone has finished.
+					cloner.Clone(slug)
+				}
+				col.Logger.Log("cloned", "slug", slug, "page", col.page)
+
+				if col.Incremental {
+					sha, _ := headSHA(dir)
+					col.cache.Set(slug, CacheEntry{
+						CloneURL:      repo.GetCloneURL(),
+						DefaultBranch: repo.GetDefaultBranch(),
+						PushedAt:      repo.GetPushedAt(),
+						SHA:           sha,
+					})
+					if err := col.cache.Save(); err != nil {
+						return 0, 0, err
+					}
+				}
+			}
+			count++
+			if col.Count > 0 && count >= col.Count {
+				break Fetch
+			}
+		}
+
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
+		col.page++
+	}
 
-		if res.GetIncompleteResults() {
-			log.Println("TODO: Handle incomplete result returned from GitHub API")
+	if col.source() != SourceSearch {
+		// ListByOrg/List are paginated without the Search API's 1000-result ceiling,
+		// so every enumerated repository is also the total.
+		total = enumerated
+	}
+
+	if !col.Dry {
+		cloner.Shutdown()
+		col.Logger.Log("collected", "count", count, "total", total, "dest", col.Dest, "elapsed", time.Now().Sub(start).Seconds())
+	}
+
+	return count, total, nil
+}
+
+// collectGists drives SourceGist. Filter is not consulted since it is typed for
+// *github.Repository.
+func (col *Collector) collectGists() (int, int, error) {
+	col.Logger.Log("fetching gists", "user", col.Query)
+	start := time.Now()
+	cloner := NewCloner(col.Dest, col.Extract, col.Deep, col.SSH, col.host, col.Metrics)
+	if !col.Dry {
+		cloner.Start(col.Count)
+	}
+
+	count := 0
+	total := 0
+Fetch:
+	for col.page <= col.maxPage {
+		gists, resp, err := col.listGists()
+		if col.trackRate(resp, err) {
+			continue
+		} else if err != nil {
+			col.Metrics.IncAPIErrors()
+			return 0, 0, err
 		}
 
-		if len(res.Repositories) == 0 {
-			// All repositories were searched
+		if len(gists) == 0 {
 			break
 		}
+		col.Metrics.IncReposEnumerated(len(gists))
+		total += len(gists)
 
-		for _, repo := range res.Repositories {
-			slug := fmt.Sprintf("%s/%s", repo.GetOwner().GetLogin(), repo.GetName())
+		for _, gist := range gists {
+			slug := fmt.Sprintf("%s/%s", gist.GetOwner().GetLogin(), gist.GetID())
 			if col.Dry {
-				fmt.Printf("dry-run: %s: %s\n", slug, repo.GetDescription())
+				fmt.Printf("dry-run: %s: %s\n", slug, gist.GetDescription())
 			} else {
 				cloner.Clone(slug)
+				col.Logger.Log("cloned", "slug", slug, "page", col.page)
 			}
 			count++
 			if col.Count > 0 && count >= col.Count {
@@ -101,12 +386,15 @@ Fetch:
 			}
 		}
 
+		if resp == nil || resp.NextPage == 0 {
+			break
+		}
 		col.page++
 	}
 
 	if !col.Dry {
 		cloner.Shutdown()
-		log.Printf("%d repositories were cloned into '%s' for total %d search results (%f seconds)\n", count, col.Dest, total, time.Now().Sub(start).Seconds())
+		col.Logger.Log("collected", "count", count, "dest", col.Dest, "elapsed", time.Now().Sub(start).Seconds())
 	}
 
 	return count, total, nil
@@ -125,33 +413,71 @@ type PageConfig struct {
 // PageUnlimited means to fetch and clone repositories as much as possible.
 const PageUnlimited uint = 0
 
-// NewCollector creates Collector instance.
-func NewCollector(query, token, dest string, extract *regexp.Regexp, count int, dry bool, deep bool, ssh bool, page *PageConfig) *Collector {
+// NewCollector creates Collector instance. tokens is a pool of personal access tokens
+// to rotate between when the Search API's rate limit is hit; a nil or empty slice
+// means unauthenticated requests.
+func NewCollector(query string, tokens []string, dest string, extract *regexp.Regexp, count int, dry bool, deep bool, ssh bool, page *PageConfig, endpoint *EndpointConfig) (*Collector, error) {
 	ctx := context.Background()
 
-	var auth *http.Client
-	if token != "" {
-		src := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)
-		auth = oauth2.NewClient(ctx, src)
+	host := "github.com"
+	uploadURL := ""
+	if endpoint != nil && endpoint.BaseURL != "" {
+		uploadURL = endpoint.UploadURL
+		if uploadURL == "" {
+			uploadURL = endpoint.BaseURL
+		}
+		if u, err := url.Parse(endpoint.BaseURL); err == nil && u.Host != "" {
+			host = u.Host
+		}
 	}
 
-	client := github.NewClient(auth)
-	c := &Collector{100, PageUnlimited, 1, query, dest, extract, count, dry, deep, ssh, client, ctx}
+	if len(tokens) == 0 {
+		tokens = []string{""}
+	}
+	clients := make([]*github.Client, len(tokens))
+	for i, token := range tokens {
+		var auth *http.Client
+		if token != "" {
+			src := oauth2.StaticTokenSource(
+				&oauth2.Token{AccessToken: token},
+			)
+			auth = oauth2.NewClient(ctx, src)
+		}
+		if endpoint != nil && endpoint.BaseURL != "" {
+			c, err := github.NewEnterpriseClient(endpoint.BaseURL, uploadURL, auth)
+			if err != nil {
+				return nil, fmt.Errorf("could not create enterprise client: %s", err)
+			}
+			clients[i] = c
+		} else {
+			clients[i] = github.NewClient(auth)
+		}
+	}
+
+	c := &Collector{
+		perPage: 100,
+		maxPage: PageUnlimited,
+		page:    1,
+		Query:   query,
+		Dest:    dest,
+		Extract: extract,
+		Count:   count,
+		Dry:     dry,
+		Deep:    deep,
+		SSH:     ssh,
+		Logger:  StdLogger{},
+		Metrics: NopMetrics{},
+		host:    host,
+		clients: clients,
+		resets:  make([]time.Time, len(clients)),
+		ctx:     ctx,
+	}
 
 	if page != nil {
 		c.perPage = page.Per
 		c.maxPage = page.Max
 		c.page = page.Start
 	}
-	if c.maxPage == PageUnlimited {
-		maxRepos := 1000.0
-		if 0 < count && count < 1000 {
-			maxRepos = float64(count)
-		}
-		c.maxPage = uint(math.Ceil(maxRepos / float64(c.perPage)))
-	}
 
-	return c
+	return c, nil
 }
@@ -0,0 +1,27 @@
+package ghca
+
+import "log"
+
+// Logger receives structured log events emitted by Collect, as alternating
+// key/value pairs (e.g. "slug", slug, "page", page). Implementations must be
+// safe for concurrent use.
+type Logger interface {
+	Log(msg string, kv ...interface{})
+}
+
+// StdLogger is the default Logger. It prints each event through the standard
+// log package as the message followed by its space-joined key/value pairs,
+// e.g. "2009/11/10 23:00:00 cloned slug foo/bar page 1".
+type StdLogger struct{}
+
+// Log implements Logger.
+func (StdLogger) Log(msg string, kv ...interface{}) {
+	args := append([]interface{}{msg}, kv...)
+	log.Println(args...)
+}
+
+// NopLogger is a Logger that discards every event.
+type NopLogger struct{}
+
+// Log implements Logger.
+func (NopLogger) Log(msg string, kv ...interface{}) {}
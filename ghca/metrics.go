@@ -0,0 +1,87 @@
+package ghca
+
+import "expvar"
+
+// Metrics receives counters and histograms from Collect, so that ghca can be run
+// as a long-lived mirroring service rather than only a one-shot CLI tool. Third
+// party systems such as prometheus/client_golang can be wired in by implementing
+// this interface directly.
+type Metrics interface {
+	// IncReposEnumerated counts repositories (or gists) seen, before Filter runs.
+	IncReposEnumerated(n int)
+	// IncReposCloned counts repositories actually cloned or updated.
+	IncReposCloned(n int)
+	// ObserveCloneDuration records how long a single clone took, in seconds.
+	ObserveCloneDuration(seconds float64)
+	// AddCloneBytes counts bytes written to disk by a single clone, measured from
+	// the cloned directory once "git clone" returns.
+	AddCloneBytes(n int64)
+	// IncRateLimitHits counts how many times the Search/List API returned a rate limit error.
+	IncRateLimitHits()
+	// IncAPIErrors counts non-rate-limit errors returned by the GitHub API.
+	IncAPIErrors()
+}
+
+// NopMetrics is a Metrics that discards everything. It is the default.
+type NopMetrics struct{}
+
+// IncReposEnumerated implements Metrics.
+func (NopMetrics) IncReposEnumerated(n int) {}
+
+// IncReposCloned implements Metrics.
+func (NopMetrics) IncReposCloned(n int) {}
+
+// ObserveCloneDuration implements Metrics.
+func (NopMetrics) ObserveCloneDuration(seconds float64) {}
+
+// AddCloneBytes implements Metrics.
+func (NopMetrics) AddCloneBytes(n int64) {}
+
+// IncRateLimitHits implements Metrics.
+func (NopMetrics) IncRateLimitHits() {}
+
+// IncAPIErrors implements Metrics.
+func (NopMetrics) IncAPIErrors() {}
+
+// ExpvarMetrics is a Metrics backed by the standard library's expvar package,
+// exposing counters at /debug/vars under the "ghca_" prefix.
+type ExpvarMetrics struct {
+	reposEnumerated *expvar.Int
+	reposCloned     *expvar.Int
+	cloneSeconds    *expvar.Float
+	cloneBytes      *expvar.Int
+	rateLimitHits   *expvar.Int
+	apiErrors       *expvar.Int
+}
+
+// NewExpvarMetrics publishes a fresh set of expvar counters and returns a Metrics
+// backed by them. It must only be called once per process, since the counter
+// names are fixed and expvar.Publish panics on a duplicate name.
+func NewExpvarMetrics() *ExpvarMetrics {
+	return &ExpvarMetrics{
+		reposEnumerated: expvar.NewInt("ghca_repos_enumerated"),
+		reposCloned:     expvar.NewInt("ghca_repos_cloned"),
+		cloneSeconds:    expvar.NewFloat("ghca_clone_duration_seconds"),
+		cloneBytes:      expvar.NewInt("ghca_clone_bytes"),
+		rateLimitHits:   expvar.NewInt("ghca_rate_limit_hits"),
+		apiErrors:       expvar.NewInt("ghca_api_errors"),
+	}
+}
+
+// IncReposEnumerated implements Metrics.
+func (m *ExpvarMetrics) IncReposEnumerated(n int) { m.reposEnumerated.Add(int64(n)) }
+
+// IncReposCloned implements Metrics.
+func (m *ExpvarMetrics) IncReposCloned(n int) { m.reposCloned.Add(int64(n)) }
+
+// ObserveCloneDuration implements Metrics.
+func (m *ExpvarMetrics) ObserveCloneDuration(seconds float64) { m.cloneSeconds.Add(seconds) }
+
+// AddCloneBytes implements Metrics.
+func (m *ExpvarMetrics) AddCloneBytes(n int64) { m.cloneBytes.Add(n) }
+
+// IncRateLimitHits implements Metrics.
+func (m *ExpvarMetrics) IncRateLimitHits() { m.rateLimitHits.Add(1) }
+
+// IncAPIErrors implements Metrics.
+func (m *ExpvarMetrics) IncAPIErrors() { m.apiErrors.Add(1) }